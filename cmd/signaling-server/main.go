@@ -1,27 +1,80 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/TeamMavericKX/deployn3tx/internal/auth"
+	"github.com/TeamMavericKX/deployn3tx/internal/backplane"
+	"github.com/TeamMavericKX/deployn3tx/internal/metrics"
+	"github.com/TeamMavericKX/deployn3tx/internal/sfu"
 )
 
 var (
-	addr = flag.String("addr", "localhost:8080", "http service address")
+	addr   = flag.String("addr", "localhost:8080", "http service address")
+	nodeID = flag.String("node-id", "", "unique ID for this server instance, used by the backplane (defaults to a generated ID)")
+
+	sfuUDPPortMin = flag.Uint("sfu-udp-port-min", 0, "lower bound of the ephemeral UDP port range used by SFU-mode rooms (0 lets the OS choose)")
+	sfuUDPPortMax = flag.Uint("sfu-udp-port-max", 0, "upper bound of the ephemeral UDP port range used by SFU-mode rooms (0 lets the OS choose)")
+	sfuPublicIP   = flag.String("sfu-public-ip", "", "public IP to advertise as a host candidate for SFU-mode rooms, for servers behind static 1:1 NAT")
+
+	authHMACSecret = flag.String("auth-hmac-secret", "", "shared secret used to verify HS256 JWTs; enables token auth in shared-secret mode")
+	authJWKSURL    = flag.String("auth-jwks-url", "", "OIDC JWKS endpoint used to verify RS256 JWTs; enables token auth in OIDC mode")
+
+	metricsAddr = flag.String("metrics-addr", "", "if set, serve Prometheus metrics at /metrics on this address")
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins in development
-	},
+	CheckOrigin: checkOrigin,
 }
 
+// checkOrigin rejects cross-origin upgrade requests, the standard
+// gorilla/websocket defense against CSWSH (cross-site WebSocket hijacking):
+// a request with no Origin header is same-origin by definition (not
+// browser-issued), but a present Origin must match the request's Host.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
+
+const (
+	// writeWait is the time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong message from
+	// the peer.
+	pongWait = 60 * time.Second
+	// pingPeriod sends pings at this interval; must be less than
+	// pongWait.
+	pingPeriod = (pongWait * 9) / 10
+	// maxMessageSize is the maximum message size allowed from a peer.
+	maxMessageSize = 32 * 1024
+
+	// closeCodeIDInUse is the WebSocket close code (from the private-use
+	// range above 4000) sent when a connection requests a client ID
+	// that's already registered and doesn't present that connection's
+	// reconnect nonce, so the Hub can't tell it apart from a collision.
+	closeCodeIDInUse = 4009
+)
+
 type MessageType int
 
 const (
@@ -33,6 +86,30 @@ const (
 	Register
 	Unregister
 	Broadcast
+	// Subscribe asks the SFU to start forwarding a published track to
+	// the requesting client.
+	Subscribe
+	// Unsubscribe asks the SFU to stop forwarding a previously
+	// subscribed track.
+	Unsubscribe
+	// TrackPublished notifies room members that a new RTP track became
+	// available on the SFU, so they can Subscribe to it.
+	TrackPublished
+	// ListPeers asks the hub for the room's roster (the Directory RPC),
+	// answered with the same cross-node metadata PeerDiscovery uses.
+	ListPeers
+)
+
+// RoomMode selects how peers in a room exchange media: "mesh" has every
+// client negotiate a direct PeerConnection with every other client, "sfu"
+// has the server terminate one PeerConnection per client and forward RTP
+// between them (see internal/sfu), which scales past the small peer counts
+// a full mesh can handle.
+type RoomMode string
+
+const (
+	RoomModeMesh RoomMode = "mesh"
+	RoomModeSFU  RoomMode = "sfu"
 )
 
 type SignalMessage struct {
@@ -41,6 +118,19 @@ type SignalMessage struct {
 	SenderID  string          `json:"sender_id"`
 	Timestamp time.Time       `json:"timestamp"`
 	RoomID    string          `json:"room_id"`
+	// SeqID is assigned by the hub for replayable message types (Offer,
+	// IceCandidate) and lets a reconnecting client resume from the
+	// last one it saw via ?resume_from=<seq_id>. Zero for message
+	// types that aren't buffered for replay.
+	SeqID uint64 `json:"seq_id,omitempty"`
+
+	// RecipientID, if set, routes this message to that single client
+	// instead of the whole room (unicast). RecipientIDs routes to
+	// exactly that set of clients (multicast). When both are empty the
+	// message is broadcast to the whole room, as before. At most one
+	// of RecipientID/RecipientIDs should be set.
+	RecipientID  string   `json:"recipient_id,omitempty"`
+	RecipientIDs []string `json:"recipient_ids,omitempty"`
 }
 
 type Client struct {
@@ -48,7 +138,79 @@ type Client struct {
 	Conn   *websocket.Conn
 	Send   chan []byte
 	RoomID string
-	mu     sync.RWMutex
+	// Mode is the RoomMode the client negotiated when it connected. It
+	// is fixed for the lifetime of the connection; to switch modes a
+	// client reconnects.
+	Mode RoomMode
+	// Role is the permission level granted by the Authenticator at
+	// connection time and is used to enforce per-role message ACLs.
+	Role auth.Role
+	// ResumeFrom is the last replayable SignalMessage.SeqID the client
+	// saw before reconnecting, from `?resume_from=`. Zero means no
+	// replay is requested.
+	ResumeFrom uint64
+	// Nonce is generated fresh for every connection and handed back to
+	// the client once registration succeeds. A later connection that
+	// wants to take over this client's ID (e.g. after an unclean
+	// disconnect) must present it via `?reconnect_nonce=`, which proves
+	// it's the same client reconnecting rather than someone else
+	// colliding with the ID.
+	Nonce string
+	// reconnectNonce is the nonce this connection presented via
+	// `?reconnect_nonce=`, checked against the existing registrant's
+	// Nonce in Hub.Run's Register case.
+	reconnectNonce string
+	// registered carries the outcome of this connection's registration:
+	// nil on success, or an error if ID belongs to another connection
+	// and reconnectNonce didn't match. nil channel for callers that
+	// don't need the result.
+	registered chan error
+	mu         sync.RWMutex
+
+	// sendMu guards Send against the send-on-closed-channel panic that
+	// would otherwise be reachable when a remote backplane fan-out
+	// goroutine (or an SFU PeerConnection callback, which pion also
+	// runs on its own goroutine) tries to deliver to this client at the
+	// same moment Hub.Run is tearing it down on its own goroutine:
+	// trySend and closeSend both take sendMu, so a send can never race
+	// a close.
+	sendMu sync.Mutex
+	closed bool
+}
+
+// trySend delivers payload to c.Send, reporting whether it was queued.
+// It returns false both when c's buffer is full and when c has already
+// been closed, so callers can't tell the two apart from the return value
+// alone — callers that care (the buffer-full path unregisters the client
+// with a specific cause) already hold a reference to a client that's
+// either still live or in the process of being torn down by someone else,
+// and either way there's nothing more for them to do.
+func (c *Client) trySend(payload []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeSend closes c.Send, if it hasn't been already. Safe to call
+// concurrently with trySend and with itself.
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.Send)
 }
 
 type Hub struct {
@@ -58,80 +220,513 @@ type Hub struct {
 	Register   chan *Client
 	Unregister chan *Client
 	mu         sync.RWMutex
+
+	// NodeID identifies this server instance to the Backplane so
+	// presence entries and published messages can be attributed to it.
+	NodeID string
+	// Backplane fans SignalMessages out across a fleet of signaling
+	// servers and mirrors client presence so PeerDiscovery can return
+	// the full cross-node roster. Defaults to an in-memory, single-node
+	// implementation.
+	Backplane backplane.Backplane
+
+	// SFU hosts rooms whose clients connected with mode=sfu. It is nil
+	// when the server wasn't started with an SFU configuration, in
+	// which case those clients fall back to mesh signaling.
+	SFU *sfu.Manager
+
+	// Authenticator verifies each upgrade request and grants the
+	// resulting Claims' Role to the Client. Defaults to auth.Open,
+	// which trusts the legacy query parameters and should only be used
+	// in development.
+	Authenticator auth.Authenticator
+
+	// Hooks are notified of register/unregister/broadcast events as
+	// they're processed by Run, so operators can wire audit logs or
+	// webhooks without forking the hub loop.
+	Hooks []EventHook
+
+	subscribedRooms  map[string]context.CancelFunc
+	unregisterCauses map[*Client]string
+	replayBuffers    map[string]*replayBuffer
+}
+
+// EventHook observes register/unregister/broadcast events as Hub.Run
+// processes them, so operators can wire audit logs or webhooks without
+// forking the hub loop. Implementations must not block, since they run
+// inline on the hub's single goroutine.
+type EventHook interface {
+	OnRegister(client *Client)
+	OnUnregister(client *Client, cause string)
+	OnBroadcast(message SignalMessage)
 }
 
+// metricsHook is the EventHook that keeps the Prometheus gauges and
+// counters in internal/metrics in sync with the hub's events. It is always
+// installed; metrics are cheap to maintain even when nothing scrapes them.
+type metricsHook struct{}
+
+func (metricsHook) OnRegister(client *Client) {
+	metrics.ConnectedClients.Inc()
+}
+
+func (metricsHook) OnUnregister(client *Client, cause string) {
+	metrics.ConnectedClients.Dec()
+	metrics.UnregisterTotal.WithLabelValues(cause).Inc()
+}
+
+func (metricsHook) OnBroadcast(message SignalMessage) {
+	metrics.MessagesTotal.WithLabelValues(messageTypeLabel(message.Type)).Inc()
+	metrics.ObserveLatency(message.Timestamp)
+}
+
+func messageTypeLabel(t MessageType) string {
+	switch t {
+	case Offer:
+		return "offer"
+	case Answer:
+		return "answer"
+	case IceCandidate:
+		return "ice_candidate"
+	case PeerDiscovery:
+		return "peer_discovery"
+	case Heartbeat:
+		return "heartbeat"
+	case Register:
+		return "register"
+	case Unregister:
+		return "unregister"
+	case Broadcast:
+		return "broadcast"
+	case Subscribe:
+		return "subscribe"
+	case Unsubscribe:
+		return "unsubscribe"
+	case TrackPublished:
+		return "track_published"
+	case ListPeers:
+		return "list_peers"
+	default:
+		return "unknown"
+	}
+}
+
+// NewHub returns a Hub backed by an in-memory, single-node Backplane. Use
+// NewHubWithBackplane to share room state across a fleet of servers.
 func NewHub() *Hub {
+	return NewHubWithBackplane(backplane.NewMemory(), generateClientID())
+}
+
+// NewHubWithBackplane returns a Hub that publishes and subscribes through bp
+// so room state can be shared with other signaling-server instances. nodeID
+// identifies this instance in presence records.
+func NewHubWithBackplane(bp backplane.Backplane, nodeID string) *Hub {
 	return &Hub{
-		Clients:    make(map[string]*Client),
-		Rooms:      make(map[string]map[string]*Client),
-		Broadcast:  make(chan SignalMessage),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Clients:          make(map[string]*Client),
+		Rooms:            make(map[string]map[string]*Client),
+		Broadcast:        make(chan SignalMessage),
+		Register:         make(chan *Client),
+		Unregister:       make(chan *Client),
+		NodeID:           nodeID,
+		Backplane:        bp,
+		Authenticator:    auth.Open{},
+		Hooks:            []EventHook{metricsHook{}},
+		subscribedRooms:  make(map[string]context.CancelFunc),
+		unregisterCauses: make(map[*Client]string),
+		replayBuffers:    make(map[string]*replayBuffer),
+	}
+}
+
+// fireHooks runs fn for every registered hook.
+func (h *Hub) fireHooks(fn func(EventHook)) {
+	for _, hook := range h.Hooks {
+		fn(hook)
+	}
+}
+
+// unregister queues client for removal from the hub, recording cause (e.g.
+// "connection_closed", "send_buffer_full") for the unregister-causes
+// metric and any EventHooks. The send to h.Unregister happens on its own
+// goroutine because unregister is called both from outside Hub.Run (e.g.
+// ReadPump) and, via routeLocal/ackHeartbeat/replyWithRoster/replayMissed,
+// from inside Hub.Run's own goroutine while it's processing a Register or
+// Broadcast case — a direct send would deadlock against the very select
+// loop that's supposed to receive it.
+func (h *Hub) unregister(client *Client, cause string) {
+	h.mu.Lock()
+	h.unregisterCauses[client] = cause
+	h.mu.Unlock()
+	go func() { h.Unregister <- client }()
+}
+
+// subscribeRoom starts fanning remote messages published to room, by any
+// node, out to this node's locally-connected clients. It is a no-op if the
+// room is already subscribed.
+func (h *Hub) subscribeRoom(room string) {
+	h.mu.Lock()
+	if _, ok := h.subscribedRooms[room]; ok {
+		h.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.subscribedRooms[room] = cancel
+	h.mu.Unlock()
+
+	go func() {
+		err := h.Backplane.Subscribe(ctx, room, func(msg backplane.Message) {
+			if msg.NodeID == h.NodeID {
+				return // already fanned out locally when it was published
+			}
+			h.routeLocal(room, msg.SenderID, msg.RecipientID, msg.RecipientIDs, msg.Payload)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("backplane: subscribe to room %s ended: %v", room, err)
+		}
+	}()
+}
+
+// unsubscribeRoom cancels room's backplane subscription and forgets it, so
+// a later client joining the same room ID starts a fresh subscription
+// instead of leaking the old goroutine forever. Callers must hold h.mu and
+// have already confirmed room has no more locally-connected clients.
+func (h *Hub) unsubscribeRoom(room string) {
+	cancel, ok := h.subscribedRooms[room]
+	if !ok {
+		return
+	}
+	delete(h.subscribedRooms, room)
+	cancel()
+}
+
+// routeLocal delivers payload to the locally-connected clients in room that
+// are addressed by recipientID/recipientIDs: a single client when
+// recipientID is set (unicast), exactly that set when recipientIDs is set
+// (multicast), or every client but senderID when both are empty
+// (broadcast, the original behavior). senderID is always excluded so a
+// client never receives its own message back.
+func (h *Hub) routeLocal(room, senderID, recipientID string, recipientIDs []string, payload []byte) {
+	h.mu.RLock()
+	clientsInRoom := h.Rooms[room]
+
+	var wanted map[string]bool
+	if recipientID != "" {
+		wanted = map[string]bool{recipientID: true}
+	} else if len(recipientIDs) > 0 {
+		wanted = make(map[string]bool, len(recipientIDs))
+		for _, id := range recipientIDs {
+			wanted[id] = true
+		}
+	}
+
+	recipients := make([]*Client, 0, len(clientsInRoom))
+	for _, client := range clientsInRoom {
+		if client.ID == senderID {
+			continue
+		}
+		if wanted != nil && !wanted[client.ID] {
+			continue
+		}
+		recipients = append(recipients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range recipients {
+		if !client.trySend(payload) {
+			metrics.DroppedMessagesTotal.Inc()
+			h.unregister(client, "send_buffer_full")
+		}
+	}
+}
+
+// route delivers message to its local recipients and publishes it on the
+// backplane so peer nodes can deliver it to theirs.
+func (h *Hub) route(message SignalMessage) {
+	localPayload := message.Payload
+	if message.SeqID != 0 {
+		// This message was stamped by the replay buffer, so locally
+		// connected recipients need its SeqID too, or they have no
+		// correct value to send back as ?resume_from= after a
+		// reconnect. Delivering the full envelope (rather than just
+		// the inner Payload) gives them that.
+		if envelope, err := json.Marshal(message); err != nil {
+			log.Printf("error marshaling envelope for seq %d: %v", message.SeqID, err)
+		} else {
+			localPayload = envelope
+		}
+	}
+	h.routeLocal(message.RoomID, message.SenderID, message.RecipientID, message.RecipientIDs, localPayload)
+
+	if err := h.Backplane.Publish(context.Background(), message.RoomID, backplane.Message{
+		RoomID:       message.RoomID,
+		SenderID:     message.SenderID,
+		NodeID:       h.NodeID,
+		Payload:      message.Payload,
+		RecipientID:  message.RecipientID,
+		RecipientIDs: message.RecipientIDs,
+	}); err != nil {
+		log.Printf("backplane: publish to room %s: %v", message.RoomID, err)
 	}
 }
 
 func (h *Hub) Run() {
+	ctx := context.Background()
+
 	for {
 		select {
 		case client := <-h.Register:
 			h.mu.Lock()
+			var superseded *Client
+			if existing, ok := h.Clients[client.ID]; ok {
+				if client.reconnectNonce == "" || client.reconnectNonce != existing.Nonce {
+					h.mu.Unlock()
+					if client.registered != nil {
+						client.registered <- fmt.Errorf("id %q already in use", client.ID)
+					}
+					continue
+				}
+
+				// The new connection proved it's the same client
+				// reconnecting (it knew the stale connection's
+				// nonce), so drop the stale one in its place
+				// instead of rejecting this registration. Its later
+				// Unregister (once WritePump sees the closed Send
+				// channel and closes the connection, ending its
+				// ReadPump) will find existing == client false and
+				// skip re-firing these same hooks, so fire them here
+				// instead.
+				delete(h.Clients, existing.ID)
+				if room, ok := h.Rooms[existing.RoomID]; ok {
+					delete(room, existing.ID)
+				}
+				existing.closeSend()
+				superseded = existing
+			}
+
 			h.Clients[client.ID] = client
-			
+
 			// Create room if it doesn't exist
 			if _, ok := h.Rooms[client.RoomID]; !ok {
 				h.Rooms[client.RoomID] = make(map[string]*Client)
 			}
 			h.Rooms[client.RoomID][client.ID] = client
 			h.mu.Unlock()
-			
+
+			if superseded != nil {
+				h.fireHooks(func(hook EventHook) { hook.OnUnregister(superseded, "reconnected") })
+			}
+
+			if client.registered != nil {
+				client.registered <- nil
+			}
+
+			metrics.Rooms.Set(float64(h.roomCount()))
+			h.fireHooks(func(hook EventHook) { hook.OnRegister(client) })
+
+			h.subscribeRoom(client.RoomID)
+			metadata := map[string]string{"role": string(client.Role), "mode": string(client.Mode)}
+			if err := h.Backplane.AddPresence(ctx, client.RoomID, client.ID, h.NodeID, metadata); err != nil {
+				log.Printf("backplane: add presence for %s: %v", client.ID, err)
+			}
+
+			if client.Mode == RoomModeSFU && h.SFU != nil {
+				if _, err := h.SFU.Room(client.RoomID).Join(client.ID,
+					func(candidate webrtc.ICECandidateInit) { h.sendSFUICECandidate(client, candidate) },
+					func(offer webrtc.SessionDescription) { h.sendSFUOffer(client, offer) },
+					func(sourceID string) { h.sendSFUTrackPublished(client, sourceID) },
+				); err != nil {
+					log.Printf("sfu: join room %s: %v", client.RoomID, err)
+				}
+			}
+
+			h.replayMissed(client)
+
 			log.Printf("Client %s registered in room %s", client.ID, client.RoomID)
-			
+
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.Clients[client.ID]; ok {
+			cause := h.unregisterCauses[client]
+			delete(h.unregisterCauses, client)
+			if cause == "" {
+				cause = "unknown"
+			}
+			// Only tear down this client's state if it's still the
+			// registered client for its ID: a reconnect in the
+			// Register case may have already replaced it, in which
+			// case this stale unregister must not evict the new
+			// one's map entry, presence record, metrics, or SFU
+			// subscriber.
+			existing, ok := h.Clients[client.ID]
+			stillRegistered := ok && existing == client
+			if stillRegistered {
 				delete(h.Clients, client.ID)
 				if room, ok := h.Rooms[client.RoomID]; ok {
 					delete(room, client.ID)
 					if len(room) == 0 {
 						delete(h.Rooms, client.RoomID)
+						h.unsubscribeRoom(client.RoomID)
+						delete(h.replayBuffers, client.RoomID)
 					}
 				}
-				close(client.Send)
+				client.closeSend()
 			}
 			h.mu.Unlock()
-			
-			log.Printf("Client %s unregistered", client.ID)
-			
+
+			if !stillRegistered {
+				log.Printf("Client %s unregister (cause: %s) superseded by a reconnect, ignoring", client.ID, cause)
+				continue
+			}
+
+			metrics.Rooms.Set(float64(h.roomCount()))
+			h.fireHooks(func(hook EventHook) { hook.OnUnregister(client, cause) })
+
+			if err := h.Backplane.RemovePresence(ctx, client.RoomID, client.ID, h.NodeID); err != nil {
+				log.Printf("backplane: remove presence for %s: %v", client.ID, err)
+			}
+
+			if client.Mode == RoomModeSFU && h.SFU != nil {
+				h.SFU.Room(client.RoomID).Leave(client.ID)
+			}
+
+			log.Printf("Client %s unregistered (cause: %s)", client.ID, cause)
+
 		case message := <-h.Broadcast:
-			h.mu.RLock()
-			clientsInRoom, ok := h.Rooms[message.RoomID]
-			if ok {
-				for _, client := range clientsInRoom {
-					if client.ID != message.SenderID {
-						select {
-						case client.Send <- message.Payload:
-						default:
-							// Remove client if send fails
-							h.Unregister <- client
-						}
-					}
-				}
+			h.fireHooks(func(hook EventHook) { hook.OnBroadcast(message) })
+
+			if !h.authorized(message) {
+				log.Printf("dropping %v from %s: not permitted for its role", message.Type, message.SenderID)
+				continue
+			}
+
+			if message.Type == PeerDiscovery || message.Type == ListPeers {
+				h.replyWithRoster(ctx, message)
+				continue
+			}
+
+			if message.Type == Heartbeat {
+				h.ackHeartbeat(message)
+				continue
+			}
+
+			if h.handleSFUMessage(message) {
+				continue
 			}
-			h.mu.RUnlock()
+
+			if isReplayable(message.Type) {
+				message = h.replayBufferFor(message.RoomID).record(message)
+			}
+
+			h.route(message)
 		}
 	}
 }
 
+// roomCount returns the number of open rooms for the Rooms gauge. Callers
+// must not hold h.mu.
+func (h *Hub) roomCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.Rooms)
+}
+
+// isPublishMessage reports whether t carries media-negotiation state
+// (an offer/answer/candidate or a new SFU track), which auth.RoleSubscriber
+// may not send.
+func isPublishMessage(t MessageType) bool {
+	switch t {
+	case Offer, Answer, IceCandidate, TrackPublished:
+		return true
+	default:
+		return false
+	}
+}
+
+// authorized enforces the per-role message-type ACL: only an admin may send
+// an Unregister, whatever its target, and only a publisher or admin may
+// send the media-negotiation message types of isPublishMessage (a
+// subscriber may receive signaling but not originate it, per
+// auth.RoleSubscriber's doc).
+func (h *Hub) authorized(message SignalMessage) bool {
+	if message.Type != Unregister && !isPublishMessage(message.Type) {
+		return true
+	}
+
+	h.mu.RLock()
+	sender, ok := h.Clients[message.SenderID]
+	h.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	if message.Type == Unregister {
+		return sender.Role == auth.RoleAdmin
+	}
+	return sender.Role == auth.RolePublisher || sender.Role == auth.RoleAdmin
+}
+
+// ackHeartbeat answers a Heartbeat message by echoing it back to the
+// sender only, giving application-level clients (e.g. those that can't
+// observe WebSocket control frames) a way to confirm the round trip.
+func (h *Hub) ackHeartbeat(message SignalMessage) {
+	h.mu.RLock()
+	sender, ok := h.Clients[message.SenderID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if !sender.trySend(message.Payload) {
+		metrics.DroppedMessagesTotal.Inc()
+		h.unregister(sender, "send_buffer_full")
+	}
+}
+
+// replyWithRoster answers a PeerDiscovery or ListPeers (Directory RPC)
+// message with the full cross-node roster, including role/mode metadata,
+// for the requesting client's room.
+func (h *Hub) replyWithRoster(ctx context.Context, message SignalMessage) {
+	h.mu.RLock()
+	sender, ok := h.Clients[message.SenderID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	peers, err := h.Backplane.ListPresence(ctx, message.RoomID)
+	if err != nil {
+		log.Printf("backplane: list presence for room %s: %v", message.RoomID, err)
+		return
+	}
+
+	payload, err := json.Marshal(peers)
+	if err != nil {
+		log.Printf("error marshaling roster: %v", err)
+		return
+	}
+
+	if !sender.trySend(payload) {
+		metrics.DroppedMessagesTotal.Inc()
+		h.unregister(sender, "send_buffer_full")
+	}
+}
+
 func (c *Client) ReadPump(hub *Hub) {
 	defer func() {
-		hub.Unregister <- c
+		hub.unregister(c, "connection_closed")
 		c.Conn.Close()
 	}()
 
+	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				metrics.WebSocketErrorsTotal.WithLabelValues("read").Inc()
 				log.Printf("error: %v", err)
 			}
 			break
@@ -144,77 +739,182 @@ func (c *Client) ReadPump(hub *Hub) {
 		}
 
 		signalMsg.SenderID = c.ID
+		// Timestamp drives MessageLatencySeconds, an ops metric; stamp
+		// it with the server's own receive time rather than trusting
+		// whatever the client put on the wire, which it could spoof or
+		// which could simply reflect clock skew.
+		signalMsg.Timestamp = time.Now()
 		hub.Broadcast <- signalMsg
 	}
 }
 
 func (c *Client) WritePump() {
-	defer c.Conn.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
 
 	for {
 		select {
 		case message, ok := <-c.Send:
+			c.mu.Lock()
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Channel closed, exit
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.mu.Unlock()
 				return
 			}
 
-			c.mu.Lock()
 			err := c.Conn.WriteMessage(websocket.TextMessage, message)
 			c.mu.Unlock()
-			
+
 			if err != nil {
+				metrics.WebSocketErrorsTotal.WithLabelValues("write").Inc()
 				log.Printf("write error: %v", err)
 				return
 			}
+
+		case <-ticker.C:
+			c.mu.Lock()
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := c.Conn.WriteMessage(websocket.PingMessage, nil)
+			c.mu.Unlock()
+
+			if err != nil {
+				metrics.WebSocketErrorsTotal.WithLabelValues("write").Inc()
+				log.Printf("ping error: %v", err)
+				return
+			}
 		}
 	}
 }
 
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	claims, err := hub.Authenticator.Authenticate(r)
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade error:", err)
 		return
 	}
 
-	clientID := r.URL.Query().Get("id")
+	clientID := claims.ClientID
 	if clientID == "" {
 		clientID = generateClientID()
 	}
 
-	roomID := r.URL.Query().Get("room")
-	if roomID == "" {
-		roomID = "default"
+	mode := RoomMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = RoomModeMesh
+	}
+
+	var resumeFrom uint64
+	if raw := r.URL.Query().Get("resume_from"); raw != "" {
+		resumeFrom, _ = strconv.ParseUint(raw, 10, 64)
 	}
 
 	client := &Client{
-		ID:     clientID,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
-		RoomID: roomID,
+		ID:             clientID,
+		Conn:           conn,
+		Send:           make(chan []byte, 256),
+		RoomID:         claims.RoomID,
+		Mode:           mode,
+		Role:           claims.Role,
+		ResumeFrom:     resumeFrom,
+		Nonce:          uuid.NewString(),
+		reconnectNonce: r.URL.Query().Get("reconnect_nonce"),
+		registered:     make(chan error, 1),
 	}
 
 	hub.Register <- client
+	if err := <-client.registered; err != nil {
+		log.Printf("rejecting registration for %s: %v", client.ID, err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(closeCodeIDInUse, "id-in-use"),
+			time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	ack, err := json.Marshal(struct {
+		Nonce string `json:"nonce"`
+	}{Nonce: client.Nonce})
+	if err != nil {
+		log.Printf("error marshaling registration ack: %v", err)
+	} else if !client.trySend(ack) {
+		// The client will never learn its Nonce and so can't prove a
+		// later reconnect is itself rather than an ID collision; treat
+		// that the same as any other buffer-full send failure.
+		log.Printf("error sending registration ack to %s: send buffer full", client.ID)
+		hub.unregister(client, "send_buffer_full")
+	}
 
 	go client.WritePump()
 	client.ReadPump(hub)
 }
 
+// generateClientID returns a random, collision-resistant ID suitable for
+// both client IDs and node IDs. Client IDs no longer need to be
+// orderable or time-derived now that Hub.Run rejects registrations that
+// collide with an in-use ID (see closeCodeIDInUse).
 func generateClientID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	return uuid.NewString()
 }
 
 func main() {
 	flag.Parse()
-	
-	hub := NewHub()
+
+	id := *nodeID
+	if id == "" {
+		id = generateClientID()
+	}
+
+	hub := NewHubWithBackplane(backplane.NewMemory(), id)
+
+	switch {
+	case *authHMACSecret != "":
+		hub.Authenticator = auth.NewHMACAuthenticator([]byte(*authHMACSecret))
+	case *authJWKSURL != "":
+		authenticator, err := auth.NewOIDCAuthenticator(*authJWKSURL)
+		if err != nil {
+			log.Fatalf("auth: %v", err)
+		}
+		hub.Authenticator = authenticator
+	}
+
+	if *sfuUDPPortMin != 0 || *sfuUDPPortMax != 0 || *sfuPublicIP != "" {
+		mgr, err := sfu.NewManager(sfu.Config{
+			UDPPortMin: uint16(*sfuUDPPortMin),
+			UDPPortMax: uint16(*sfuUDPPortMax),
+			PublicIP:   *sfuPublicIP,
+		})
+		if err != nil {
+			log.Fatalf("sfu: %v", err)
+		}
+		hub.SFU = mgr
+	}
+
 	go hub.Run()
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serveWs(hub, w, r)
 	})
 
+	if *metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Printf("Serving metrics on %s/metrics", *metricsAddr)
+			log.Fatal(http.ListenAndServe(*metricsAddr, metricsMux))
+		}()
+	}
+
 	log.Printf("Starting signaling server on %s", *addr)
 	log.Fatal(http.ListenAndServe(*addr, nil))
 }
\ No newline at end of file
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// replayWindow is the number of recent Offer/IceCandidate messages kept per
+// room so a reconnecting client can catch up on signaling it missed while
+// its connection was down.
+const replayWindow = 64
+
+// replayBuffer is a per-room ring buffer of recently broadcast Offer/
+// IceCandidate messages, each tagged with a monotonically increasing SeqID
+// so a reconnecting client can ask for everything after the last one it
+// saw via ?resume_from=<seq_id>.
+type replayBuffer struct {
+	mu      sync.Mutex
+	nextSeq uint64
+	entries []SignalMessage // oldest first, capped at replayWindow
+}
+
+// record assigns the next SeqID to msg, appends it to the ring, and returns
+// the stamped message.
+func (b *replayBuffer) record(msg SignalMessage) SignalMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextSeq++
+	msg.SeqID = b.nextSeq
+
+	b.entries = append(b.entries, msg)
+	if len(b.entries) > replayWindow {
+		b.entries = b.entries[len(b.entries)-replayWindow:]
+	}
+	return msg
+}
+
+// since returns every recorded message with SeqID > after, oldest first.
+func (b *replayBuffer) since(after uint64) []SignalMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []SignalMessage
+	for _, entry := range b.entries {
+		if entry.SeqID > after {
+			missed = append(missed, entry)
+		}
+	}
+	return missed
+}
+
+// replayBufferFor returns the replayBuffer for room, creating it on first
+// use.
+func (h *Hub) replayBufferFor(room string) *replayBuffer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b, ok := h.replayBuffers[room]
+	if !ok {
+		b = &replayBuffer{}
+		h.replayBuffers[room] = b
+	}
+	return b
+}
+
+// isReplayable reports whether t is a message type worth buffering for
+// reconnect replay.
+func isReplayable(t MessageType) bool {
+	return t == Offer || t == IceCandidate
+}
+
+// addressedTo reports whether msg would have been delivered to recipientID
+// had it arrived live, applying the same unicast/multicast/broadcast rules
+// as routeLocal: a single recipient when RecipientID is set, exactly that
+// set when RecipientIDs is set, or everyone but the sender when both are
+// empty. A client never receives its own message back.
+func addressedTo(msg SignalMessage, recipientID string) bool {
+	if msg.SenderID == recipientID {
+		return false
+	}
+	if msg.RecipientID != "" {
+		return msg.RecipientID == recipientID
+	}
+	if len(msg.RecipientIDs) > 0 {
+		for _, id := range msg.RecipientIDs {
+			if id == recipientID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// replayMissed sends client everything its room's replay buffer recorded
+// after client.ResumeFrom that was addressed to it, in order. It is called
+// once, right after registration.
+func (h *Hub) replayMissed(client *Client) {
+	if client.ResumeFrom == 0 {
+		return
+	}
+
+	missed := h.replayBufferFor(client.RoomID).since(client.ResumeFrom)
+	for _, msg := range missed {
+		if !addressedTo(msg, client.ID) {
+			continue
+		}
+
+		// Replayed messages carry their SeqID, so they're marshaled
+		// as the full envelope, the same shape route delivers them
+		// in to local recipients when they first arrive.
+		body, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("error marshaling replayed message: %v", err)
+			continue
+		}
+
+		if !client.trySend(body) {
+			h.unregister(client, "send_buffer_full")
+			return
+		}
+	}
+}
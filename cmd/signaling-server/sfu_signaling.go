@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/TeamMavericKX/deployn3tx/internal/metrics"
+)
+
+// sdpPayload is the Payload shape for Offer/Answer messages exchanged with
+// an SFU-mode client: the server is itself a WebRTC endpoint for that
+// client's PeerConnection, so (unlike mesh mode, where Payload is opaque to
+// the hub) it has to parse the SDP.
+type sdpPayload struct {
+	SDP string `json:"sdp"`
+}
+
+// icePayload is the Payload shape for IceCandidate messages exchanged with
+// an SFU-mode client.
+type icePayload struct {
+	Candidate     string  `json:"candidate"`
+	SDPMid        *string `json:"sdp_mid,omitempty"`
+	SDPMLineIndex *uint16 `json:"sdp_mline_index,omitempty"`
+}
+
+// subscribePayload is the Payload shape for Subscribe/Unsubscribe messages:
+// it names the room member whose published track is being (un)subscribed
+// from.
+type subscribePayload struct {
+	SourceID string `json:"source_id"`
+}
+
+// trackPublishedPayload is the Payload shape for TrackPublished messages.
+type trackPublishedPayload struct {
+	SourceID string `json:"source_id"`
+}
+
+// handleSFUMessage handles the message types that negotiate directly with
+// an SFU-mode sender's server-side PeerConnection (Offer, Answer,
+// IceCandidate, Subscribe, Unsubscribe) instead of letting them fall
+// through to the ordinary mesh route/replay path, and reports whether it
+// did so.
+func (h *Hub) handleSFUMessage(message SignalMessage) bool {
+	if h.SFU == nil {
+		return false
+	}
+
+	h.mu.RLock()
+	sender, ok := h.Clients[message.SenderID]
+	h.mu.RUnlock()
+	if !ok || sender.Mode != RoomModeSFU {
+		return false
+	}
+
+	switch message.Type {
+	case Offer:
+		h.handleSFUOffer(sender, message)
+	case Answer:
+		h.handleSFUAnswer(sender, message)
+	case IceCandidate:
+		h.handleSFUICECandidate(sender, message)
+	case Subscribe:
+		h.handleSFUSubscribe(sender, message)
+	case Unsubscribe:
+		h.handleSFUUnsubscribe(sender, message)
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *Hub) handleSFUOffer(sender *Client, message SignalMessage) {
+	var payload sdpPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		log.Printf("sfu: unmarshal offer from %s: %v", sender.ID, err)
+		return
+	}
+
+	sub, ok := h.SFU.Room(sender.RoomID).Subscriber(sender.ID)
+	if !ok {
+		log.Printf("sfu: offer from %s: not joined to room %s", sender.ID, sender.RoomID)
+		return
+	}
+
+	answer, err := sub.HandleOffer(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: payload.SDP})
+	if err != nil {
+		log.Printf("sfu: handle offer from %s: %v", sender.ID, err)
+		return
+	}
+	h.sendSFUAnswer(sender, *answer)
+}
+
+func (h *Hub) handleSFUAnswer(sender *Client, message SignalMessage) {
+	var payload sdpPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		log.Printf("sfu: unmarshal answer from %s: %v", sender.ID, err)
+		return
+	}
+
+	sub, ok := h.SFU.Room(sender.RoomID).Subscriber(sender.ID)
+	if !ok {
+		log.Printf("sfu: answer from %s: not joined to room %s", sender.ID, sender.RoomID)
+		return
+	}
+
+	if err := sub.HandleAnswer(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: payload.SDP}); err != nil {
+		log.Printf("sfu: handle answer from %s: %v", sender.ID, err)
+	}
+}
+
+func (h *Hub) handleSFUICECandidate(sender *Client, message SignalMessage) {
+	var payload icePayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		log.Printf("sfu: unmarshal ICE candidate from %s: %v", sender.ID, err)
+		return
+	}
+
+	sub, ok := h.SFU.Room(sender.RoomID).Subscriber(sender.ID)
+	if !ok {
+		log.Printf("sfu: ICE candidate from %s: not joined to room %s", sender.ID, sender.RoomID)
+		return
+	}
+
+	candidate := webrtc.ICECandidateInit{
+		Candidate:     payload.Candidate,
+		SDPMid:        payload.SDPMid,
+		SDPMLineIndex: payload.SDPMLineIndex,
+	}
+	if err := sub.AddICECandidate(candidate); err != nil {
+		log.Printf("sfu: add ICE candidate from %s: %v", sender.ID, err)
+	}
+}
+
+func (h *Hub) handleSFUSubscribe(sender *Client, message SignalMessage) {
+	var payload subscribePayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		log.Printf("sfu: unmarshal subscribe from %s: %v", sender.ID, err)
+		return
+	}
+
+	if err := h.SFU.Room(sender.RoomID).Subscribe(sender.ID, payload.SourceID); err != nil {
+		log.Printf("sfu: %v", err)
+	}
+}
+
+func (h *Hub) handleSFUUnsubscribe(sender *Client, message SignalMessage) {
+	var payload subscribePayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		log.Printf("sfu: unmarshal unsubscribe from %s: %v", sender.ID, err)
+		return
+	}
+
+	h.SFU.Room(sender.RoomID).Unsubscribe(sender.ID, payload.SourceID)
+}
+
+func (h *Hub) sendSFUAnswer(client *Client, answer webrtc.SessionDescription) {
+	h.sendToClient(client, Answer, sdpPayload{SDP: answer.SDP})
+}
+
+func (h *Hub) sendSFUOffer(client *Client, offer webrtc.SessionDescription) {
+	h.sendToClient(client, Offer, sdpPayload{SDP: offer.SDP})
+}
+
+func (h *Hub) sendSFUICECandidate(client *Client, candidate webrtc.ICECandidateInit) {
+	h.sendToClient(client, IceCandidate, icePayload{
+		Candidate:     candidate.Candidate,
+		SDPMid:        candidate.SDPMid,
+		SDPMLineIndex: candidate.SDPMLineIndex,
+	})
+}
+
+func (h *Hub) sendSFUTrackPublished(client *Client, sourceID string) {
+	h.sendToClient(client, TrackPublished, trackPublishedPayload{SourceID: sourceID})
+}
+
+// sendToClient marshals payload and delivers it to client alone, as a
+// SignalMessage of type t addressed via RecipientID. Used for the
+// server-originated SFU signaling (answers, offers, ICE candidates, track
+// announcements) that doesn't come from another client and so never goes
+// through Hub.route.
+func (h *Hub) sendToClient(client *Client, t MessageType, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("sfu: marshal %v payload for %s: %v", t, client.ID, err)
+		return
+	}
+
+	envelope, err := json.Marshal(SignalMessage{
+		Type:        t,
+		Payload:     body,
+		SenderID:    h.NodeID,
+		Timestamp:   time.Now(),
+		RoomID:      client.RoomID,
+		RecipientID: client.ID,
+	})
+	if err != nil {
+		log.Printf("sfu: marshal envelope for %s: %v", client.ID, err)
+		return
+	}
+
+	if !client.trySend(envelope) {
+		metrics.DroppedMessagesTotal.Inc()
+		h.unregister(client, "send_buffer_full")
+	}
+}
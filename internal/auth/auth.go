@@ -0,0 +1,66 @@
+// Package auth authenticates and authorizes signaling-server clients.
+// serveWs previously accepted any client ID and room name from URL query
+// params with no verification; Authenticator lets that be replaced with a
+// signed-token scheme while keeping an explicit escape hatch (Open) for
+// local development.
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Role is the permission level granted to an authenticated client.
+type Role string
+
+const (
+	// RolePublisher may send and receive signaling messages for itself.
+	RolePublisher Role = "publisher"
+	// RoleSubscriber may receive signaling messages but not send
+	// offers, answers, ICE candidates or track announcements.
+	RoleSubscriber Role = "subscriber"
+	// RoleAdmin may additionally target management messages (such as
+	// Unregister) at other clients in the room.
+	RoleAdmin Role = "admin"
+)
+
+// ErrUnauthenticated is returned by Authenticate when the request carries
+// no usable credential.
+var ErrUnauthenticated = errors.New("auth: missing or invalid credentials")
+
+// Claims is the identity and authorization a client presented, regardless
+// of which Authenticator produced it.
+type Claims struct {
+	// ClientID is the authenticated client identity. It takes
+	// precedence over any client-supplied `?id=` query parameter.
+	ClientID string
+	// RoomID is the room the client is authorized to join. It takes
+	// precedence over any client-supplied `?room=` query parameter.
+	RoomID string
+	// Role is the client's permission level within RoomID.
+	Role Role
+}
+
+// Authenticator verifies an inbound WebSocket upgrade request and returns
+// the Claims it is authorized to act as.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Claims, error)
+}
+
+// Open is an Authenticator that accepts every request, deriving Claims from
+// the legacy `?id=`/`?room=` query parameters with RolePublisher. It exists
+// so local development and tests can run without standing up a token
+// issuer; it must not be used in production.
+type Open struct{}
+
+func (Open) Authenticate(r *http.Request) (*Claims, error) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = "default"
+	}
+	return &Claims{
+		ClientID: r.URL.Query().Get("id"),
+		RoomID:   room,
+		Role:     RolePublisher,
+	}, nil
+}
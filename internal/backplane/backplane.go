@@ -0,0 +1,62 @@
+// Package backplane lets a fleet of signaling servers share room state
+// instead of each instance owning its clients in isolation. A Backplane
+// publishes signaling messages to every other node subscribed to the same
+// room and mirrors client presence so PeerDiscovery can return the full
+// cross-node roster rather than just the clients connected to this process.
+package backplane
+
+import "context"
+
+// Message is the wire-level representation of a SignalMessage as it
+// travels between nodes. It intentionally avoids any dependency on the
+// signaling-server package to keep the backplane drivers reusable.
+type Message struct {
+	RoomID   string
+	SenderID string
+	NodeID   string
+	Payload  []byte
+
+	// RecipientID/RecipientIDs mirror SignalMessage's unicast/multicast
+	// targeting so a subscribing node can route the message to the
+	// right local clients instead of broadcasting it to the room.
+	RecipientID  string
+	RecipientIDs []string
+}
+
+// Presence describes a client registered in a room on some node of the
+// fleet, along with whatever metadata (role, room mode, ...) the directory
+// RPC should surface about it.
+type Presence struct {
+	ClientID string
+	NodeID   string
+	Metadata map[string]string
+}
+
+// Backplane is implemented by every horizontal-scaling driver (in-memory,
+// Redis, NATS, ...). All methods must be safe for concurrent use.
+type Backplane interface {
+	// Publish fans a message out to every node subscribed to room,
+	// including the publisher (Hub.Run is responsible for not looping
+	// messages back to their own local clients a second time).
+	Publish(ctx context.Context, room string, msg Message) error
+
+	// Subscribe delivers every message published to room, from any
+	// node, to fn until ctx is canceled. Subscribe may be called once
+	// per room and blocks until ctx is done or an unrecoverable error
+	// occurs.
+	Subscribe(ctx context.Context, room string, fn func(Message)) error
+
+	// AddPresence records that clientID is registered in room on this
+	// node, along with metadata describing it (e.g. "role", "mode").
+	AddPresence(ctx context.Context, room, clientID, nodeID string, metadata map[string]string) error
+
+	// RemovePresence removes the presence record added by AddPresence.
+	RemovePresence(ctx context.Context, room, clientID, nodeID string) error
+
+	// ListPresence returns every client registered in room across the
+	// whole fleet.
+	ListPresence(ctx context.Context, room string) ([]Presence, error)
+
+	// Close releases any underlying connections.
+	Close() error
+}
@@ -0,0 +1,100 @@
+package backplane
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is a single-process Backplane: it fans messages out to local
+// subscribers only and keeps presence in a plain map. It is the default
+// driver for single-node deployments and for tests, and behaves as if
+// every client were on the same node.
+type Memory struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[uint64]func(Message)
+	nextSubID   uint64
+	presence    map[string]map[string]Presence // room -> clientID -> Presence
+}
+
+// NewMemory returns a ready-to-use in-memory Backplane.
+func NewMemory() *Memory {
+	return &Memory{
+		subscribers: make(map[string]map[uint64]func(Message)),
+		presence:    make(map[string]map[string]Presence),
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, room string, msg Message) error {
+	m.mu.RLock()
+	fns := make([]func(Message), 0, len(m.subscribers[room]))
+	for _, fn := range m.subscribers[room] {
+		fns = append(fns, fn)
+	}
+	m.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(msg)
+	}
+	return nil
+}
+
+// Subscribe registers fn under a unique id so it can be removed again, by
+// that id alone, once ctx is canceled — Hub.subscribeRoom/unsubscribeRoom
+// tear down and recreate a subscription every time a room empties and
+// refills, and without this the closure from every past room-churn event
+// would stay in m.subscribers forever.
+func (m *Memory) Subscribe(ctx context.Context, room string, fn func(Message)) error {
+	m.mu.Lock()
+	m.nextSubID++
+	id := m.nextSubID
+	if m.subscribers[room] == nil {
+		m.subscribers[room] = make(map[uint64]func(Message))
+	}
+	m.subscribers[room][id] = fn
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	delete(m.subscribers[room], id)
+	if len(m.subscribers[room]) == 0 {
+		delete(m.subscribers, room)
+	}
+	m.mu.Unlock()
+
+	return ctx.Err()
+}
+
+func (m *Memory) AddPresence(ctx context.Context, room, clientID, nodeID string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.presence[room]; !ok {
+		m.presence[room] = make(map[string]Presence)
+	}
+	m.presence[room][clientID] = Presence{ClientID: clientID, NodeID: nodeID, Metadata: metadata}
+	return nil
+}
+
+func (m *Memory) RemovePresence(ctx context.Context, room, clientID, nodeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if room, ok := m.presence[room]; ok {
+		delete(room, clientID)
+	}
+	return nil
+}
+
+func (m *Memory) ListPresence(ctx context.Context, room string) ([]Presence, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make([]Presence, 0, len(m.presence[room]))
+	for _, p := range m.presence[room] {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (m *Memory) Close() error { return nil }
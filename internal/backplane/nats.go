@@ -0,0 +1,128 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS is a Backplane backed by core NATS subjects for message fan-out and
+// a NATS KV bucket for presence.
+type NATS struct {
+	conn *nats.Conn
+	kv   nats.KeyValue
+}
+
+// NewNATS returns a Backplane that publishes on subjects scoped to conn and
+// stores presence in kv. kv is typically obtained via
+// js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "signaling-presence"}).
+func NewNATS(conn *nats.Conn, kv nats.KeyValue) *NATS {
+	return &NATS{conn: conn, kv: kv}
+}
+
+func (n *NATS) Publish(ctx context.Context, room string, msg Message) error {
+	body, err := json.Marshal(wireMessage{
+		SenderID:     msg.SenderID,
+		NodeID:       msg.NodeID,
+		Payload:      msg.Payload,
+		RecipientID:  msg.RecipientID,
+		RecipientIDs: msg.RecipientIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("backplane: marshal message: %w", err)
+	}
+	return n.conn.Publish(subjectName(room), body)
+}
+
+func (n *NATS) Subscribe(ctx context.Context, room string, fn func(Message)) error {
+	sub, err := n.conn.Subscribe(subjectName(room), func(m *nats.Msg) {
+		var wm wireMessage
+		if err := json.Unmarshal(m.Data, &wm); err != nil {
+			return
+		}
+		fn(Message{
+			RoomID:       room,
+			SenderID:     wm.SenderID,
+			NodeID:       wm.NodeID,
+			Payload:      wm.Payload,
+			RecipientID:  wm.RecipientID,
+			RecipientIDs: wm.RecipientIDs,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (n *NATS) AddPresence(ctx context.Context, room, clientID, nodeID string, metadata map[string]string) error {
+	entry, err := json.Marshal(presenceEntry{NodeID: nodeID, Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("backplane: marshal presence for %s: %w", clientID, err)
+	}
+	_, err = n.kv.Put(presenceEntryKey(room, clientID), entry)
+	return err
+}
+
+func (n *NATS) RemovePresence(ctx context.Context, room, clientID, nodeID string) error {
+	return n.kv.Delete(presenceEntryKey(room, clientID))
+}
+
+func (n *NATS) ListPresence(ctx context.Context, room string) ([]Presence, error) {
+	keys, err := n.kv.Keys()
+	if err != nil {
+		if err == nats.ErrNoKeysFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := presenceRoomPrefix(room)
+	var out []Presence
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		kvEntry, err := n.kv.Get(key)
+		if err != nil {
+			continue
+		}
+		var entry presenceEntry
+		if err := json.Unmarshal(kvEntry.Value(), &entry); err != nil {
+			continue
+		}
+		out = append(out, Presence{ClientID: key[len(prefix):], NodeID: entry.NodeID, Metadata: entry.Metadata})
+	}
+	return out, nil
+}
+
+func (n *NATS) Close() error {
+	n.conn.Close()
+	return nil
+}
+
+func subjectName(room string) string {
+	return "signaling.room." + room
+}
+
+// presenceEntryKey encodes room and clientID into a NATS KV key, with
+// room's byte length embedded so presenceRoomPrefix can match it back
+// unambiguously. Without the length, a plain "room+'.'+clientID" key for
+// room "foo.bar" (e.g. "foo.bar.baz") would also satisfy a naive "foo."
+// prefix check intended to match room "foo" alone, leaking "foo.bar"'s
+// clients into "foo"'s roster.
+func presenceEntryKey(room, clientID string) string {
+	return fmt.Sprintf("%d.%s.%s", len(room), room, clientID)
+}
+
+// presenceRoomPrefix returns the exact, collision-free prefix every
+// presenceEntryKey for room produces.
+func presenceRoomPrefix(room string) string {
+	return fmt.Sprintf("%d.%s.", len(room), room)
+}
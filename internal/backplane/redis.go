@@ -0,0 +1,123 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// wireMessage is the JSON envelope published on the Redis Pub/Sub channel.
+type wireMessage struct {
+	SenderID     string   `json:"sender_id"`
+	NodeID       string   `json:"node_id"`
+	Payload      []byte   `json:"payload"`
+	RecipientID  string   `json:"recipient_id,omitempty"`
+	RecipientIDs []string `json:"recipient_ids,omitempty"`
+}
+
+// presenceEntry is the JSON value stored per clientID in the room's
+// presence hash.
+type presenceEntry struct {
+	NodeID   string            `json:"node_id"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Redis is a Backplane backed by Redis Pub/Sub for message fan-out and a
+// Redis HASH per room for presence.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns a Backplane that uses client for pub/sub and presence.
+// Presence entries are stored as fields of a HASH under the key
+// "backplane:presence:<room>", keyed by clientID with a JSON-encoded
+// node ID and metadata as the value.
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+func (r *Redis) Publish(ctx context.Context, room string, msg Message) error {
+	body, err := json.Marshal(wireMessage{
+		SenderID:     msg.SenderID,
+		NodeID:       msg.NodeID,
+		Payload:      msg.Payload,
+		RecipientID:  msg.RecipientID,
+		RecipientIDs: msg.RecipientIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("backplane: marshal message: %w", err)
+	}
+	return r.client.Publish(ctx, channelName(room), body).Err()
+}
+
+func (r *Redis) Subscribe(ctx context.Context, room string, fn func(Message)) error {
+	sub := r.client.Subscribe(ctx, channelName(room))
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var wm wireMessage
+			if err := json.Unmarshal([]byte(m.Payload), &wm); err != nil {
+				continue
+			}
+			fn(Message{
+				RoomID:       room,
+				SenderID:     wm.SenderID,
+				NodeID:       wm.NodeID,
+				Payload:      wm.Payload,
+				RecipientID:  wm.RecipientID,
+				RecipientIDs: wm.RecipientIDs,
+			})
+		}
+	}
+}
+
+func (r *Redis) AddPresence(ctx context.Context, room, clientID, nodeID string, metadata map[string]string) error {
+	entry, err := json.Marshal(presenceEntry{NodeID: nodeID, Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("backplane: marshal presence for %s: %w", clientID, err)
+	}
+	return r.client.HSet(ctx, presenceKey(room), clientID, entry).Err()
+}
+
+func (r *Redis) RemovePresence(ctx context.Context, room, clientID, nodeID string) error {
+	return r.client.HDel(ctx, presenceKey(room), clientID).Err()
+}
+
+func (r *Redis) ListPresence(ctx context.Context, room string) ([]Presence, error) {
+	fields, err := r.client.HGetAll(ctx, presenceKey(room)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Presence, 0, len(fields))
+	for clientID, raw := range fields {
+		var entry presenceEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			continue
+		}
+		out = append(out, Presence{ClientID: clientID, NodeID: entry.NodeID, Metadata: entry.Metadata})
+	}
+	return out, nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+func channelName(room string) string {
+	return "backplane:room:" + room
+}
+
+func presenceKey(room string) string {
+	return "backplane:presence:" + room
+}
@@ -0,0 +1,67 @@
+// Package metrics exposes the signaling server's operational state in
+// Prometheus text format: connected clients, rooms, messages by type,
+// dropped messages, unregister causes, WebSocket errors, and message
+// latency.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signaling_connected_clients",
+		Help: "Number of clients currently registered with the hub.",
+	})
+
+	Rooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "signaling_rooms",
+		Help: "Number of rooms currently open.",
+	})
+
+	MessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_messages_total",
+		Help: "Messages processed by the hub's Broadcast loop, by MessageType.",
+	}, []string{"type"})
+
+	DroppedMessagesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "signaling_dropped_messages_total",
+		Help: "Messages dropped because a client's send buffer was full.",
+	})
+
+	UnregisterTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_unregister_total",
+		Help: "Client unregistrations, by cause.",
+	}, []string{"cause"})
+
+	WebSocketErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "signaling_websocket_errors_total",
+		Help: "WebSocket read/write errors, by direction.",
+	}, []string{"direction"})
+
+	MessageLatencySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "signaling_message_latency_seconds",
+		Help:    "Time between a SignalMessage's Timestamp and the hub processing it.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// ObserveLatency records the time elapsed since sentAt, as measured
+// against SignalMessage.Timestamp, for the message latency histogram.
+func ObserveLatency(sentAt time.Time) {
+	if sentAt.IsZero() {
+		return
+	}
+	MessageLatencySeconds.Observe(time.Since(sentAt).Seconds())
+}
+
+// Handler serves the Prometheus text exposition format for all metrics
+// registered in this package, for mounting at /metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
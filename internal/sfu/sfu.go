@@ -0,0 +1,390 @@
+// Package sfu implements an optional selective-forwarding-unit mode for
+// rooms that would otherwise exceed the practical size of a full mesh. The
+// server terminates one pion/webrtc PeerConnection per client and receives
+// their published RTP tracks. Forwarding is opt-in: a newly published track
+// is only announced to other room members (via Room.Join's
+// onTrackPublished callback), and is only actually forwarded, via a
+// TrackLocalStaticRTP, to the members that call Room.Subscribe for it.
+package sfu
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Config holds the settings needed to construct PeerConnections for SFU
+// rooms.
+type Config struct {
+	// UDPPortMin and UDPPortMax bound the ephemeral UDP port range used
+	// for RTP/RTCP, so the range can be opened in firewalls/NAT rules.
+	UDPPortMin uint16
+	UDPPortMax uint16
+	// PublicIP is advertised as a host candidate so clients behind NAT
+	// can reach this server directly instead of relying on a TURN relay.
+	PublicIP string
+}
+
+// Manager owns one Room per SFU-mode room and the webrtc.API configured
+// from Config.
+type Manager struct {
+	api *webrtc.API
+
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+// NewManager builds the pion/webrtc API (UDP port range + public IP NAT
+// mapping) described by cfg and returns a Manager ready to host SFU rooms.
+func NewManager(cfg Config) (*Manager, error) {
+	settingEngine := webrtc.SettingEngine{}
+
+	if cfg.UDPPortMin != 0 || cfg.UDPPortMax != 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(cfg.UDPPortMin, cfg.UDPPortMax); err != nil {
+			return nil, fmt.Errorf("sfu: set UDP port range: %w", err)
+		}
+	}
+	if cfg.PublicIP != "" {
+		settingEngine.SetNAT1To1IPs([]string{cfg.PublicIP}, webrtc.ICECandidateTypeHost)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+
+	return &Manager{api: api, rooms: make(map[string]*Room)}, nil
+}
+
+// Room returns the Room for roomID, creating it on first use.
+func (m *Manager) Room(roomID string) *Room {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		room = &Room{
+			id:            roomID,
+			api:           m.api,
+			subscribers:   make(map[string]*Subscriber),
+			published:     make(map[string]*webrtc.TrackRemote),
+			subscriptions: make(map[string]map[string]bool),
+		}
+		m.rooms[roomID] = room
+	}
+	return room
+}
+
+// Subscriber is one client's PeerConnection within an SFU Room: it both
+// publishes its own track(s) and receives tracks it has explicitly
+// subscribed to via Room.Subscribe.
+type Subscriber struct {
+	ClientID string
+	PC       *webrtc.PeerConnection
+
+	mu              sync.Mutex
+	outbound        map[string]*webrtc.TrackLocalStaticRTP // keyed by source subscriber ID
+	outboundSenders map[string]*webrtc.RTPSender           // keyed by source subscriber ID
+
+	// renegotiate is called with a freshly created+set local offer
+	// whenever outbound gains or loses a track, so the caller can ship
+	// it to the client over the signaling channel.
+	renegotiate func(offer webrtc.SessionDescription)
+	// onICECandidate is called with every local ICE candidate gathered
+	// for PC, so the caller can relay it to the client.
+	onICECandidate func(candidate webrtc.ICECandidateInit)
+	// onTrackPublished is called with the ClientID of a room member
+	// whose track became available to subscribe to (including, at Join
+	// time, tracks already published by other members).
+	onTrackPublished func(sourceID string)
+}
+
+// Room fans RTP packets published by any one Subscriber out to the
+// Subscribers that have explicitly subscribed to that source's track.
+type Room struct {
+	id  string
+	api *webrtc.API
+
+	mu            sync.RWMutex
+	subscribers   map[string]*Subscriber
+	published     map[string]*webrtc.TrackRemote // keyed by publishing subscriber ID
+	subscriptions map[string]map[string]bool     // source subscriber ID -> set of subscriber IDs receiving it
+}
+
+// Join creates a PeerConnection for clientID and wires OnTrack to publish
+// its RTP and OnICECandidate to relay gathered candidates. onTrackPublished
+// is called once per track already published by another room member (so a
+// late joiner learns the current roster of publishable tracks) and again
+// for every later publish; it does not itself start forwarding media — the
+// caller must call Subscribe for that. renegotiate is called with a
+// freshly set local offer whenever a track is added to or removed from
+// clientID's outbound set, so the caller can ship it down the signaling
+// channel.
+func (r *Room) Join(clientID string, onICECandidate func(candidate webrtc.ICECandidateInit), renegotiate func(offer webrtc.SessionDescription), onTrackPublished func(sourceID string)) (*Subscriber, error) {
+	pc, err := r.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, fmt.Errorf("sfu: new peer connection for %s: %w", clientID, err)
+	}
+
+	sub := &Subscriber{
+		ClientID:         clientID,
+		PC:               pc,
+		outbound:         make(map[string]*webrtc.TrackLocalStaticRTP),
+		outboundSenders:  make(map[string]*webrtc.RTPSender),
+		renegotiate:      renegotiate,
+		onICECandidate:   onICECandidate,
+		onTrackPublished: onTrackPublished,
+	}
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		r.forward(sub, remote)
+	})
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil || sub.onICECandidate == nil {
+			return
+		}
+		sub.onICECandidate(candidate.ToJSON())
+	})
+
+	r.mu.Lock()
+	r.subscribers[clientID] = sub
+	alreadyPublished := make([]string, 0, len(r.published))
+	for sourceID := range r.published {
+		alreadyPublished = append(alreadyPublished, sourceID)
+	}
+	r.mu.Unlock()
+
+	for _, sourceID := range alreadyPublished {
+		onTrackPublished(sourceID)
+	}
+
+	return sub, nil
+}
+
+// Leave tears down clientID's PeerConnection and removes it from every
+// other subscriber's subscription set.
+func (r *Room) Leave(clientID string) {
+	r.mu.Lock()
+	sub, ok := r.subscribers[clientID]
+	delete(r.subscribers, clientID)
+	delete(r.published, clientID)
+	delete(r.subscriptions, clientID)
+	for _, subscriberIDs := range r.subscriptions {
+		delete(subscriberIDs, clientID)
+	}
+	r.mu.Unlock()
+
+	if ok {
+		sub.PC.Close()
+	}
+}
+
+// Subscriber returns the Subscriber for clientID, if it has joined the room.
+func (r *Room) Subscriber(clientID string) (*Subscriber, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sub, ok := r.subscribers[clientID]
+	return sub, ok
+}
+
+// Subscribe starts forwarding sourceID's published track to subscriberID,
+// triggering a renegotiation offer to subscriberID. It fails if sourceID
+// isn't currently publishing.
+func (r *Room) Subscribe(subscriberID, sourceID string) error {
+	r.mu.Lock()
+	dest, destOK := r.subscribers[subscriberID]
+	remote, sourceOK := r.published[sourceID]
+	if sourceOK {
+		if _, ok := r.subscriptions[sourceID]; !ok {
+			r.subscriptions[sourceID] = make(map[string]bool)
+		}
+		r.subscriptions[sourceID][subscriberID] = true
+	}
+	r.mu.Unlock()
+
+	if !destOK {
+		return fmt.Errorf("sfu: subscribe: %s is not in the room", subscriberID)
+	}
+	if !sourceOK {
+		return fmt.Errorf("sfu: subscribe: %s is not publishing", sourceID)
+	}
+
+	dest.addLocalTrack(sourceID, remote.Codec().RTPCodecCapability, remote.StreamID())
+	return nil
+}
+
+// Unsubscribe stops forwarding sourceID's track to subscriberID and
+// triggers a renegotiation offer reflecting the removed track.
+func (r *Room) Unsubscribe(subscriberID, sourceID string) {
+	r.mu.Lock()
+	if subscriberIDs, ok := r.subscriptions[sourceID]; ok {
+		delete(subscriberIDs, subscriberID)
+	}
+	dest, ok := r.subscribers[subscriberID]
+	r.mu.Unlock()
+
+	if ok {
+		dest.removeLocalTrack(sourceID)
+	}
+}
+
+// forward registers source as publishing remote, announces it to every
+// other room member via onTrackPublished, then reads RTP packets from it
+// and writes them to every subscriber currently subscribed to source.
+func (r *Room) forward(source *Subscriber, remote *webrtc.TrackRemote) {
+	r.mu.Lock()
+	r.published[source.ClientID] = remote
+	others := make([]*Subscriber, 0, len(r.subscribers))
+	for id, dest := range r.subscribers {
+		if id != source.ClientID {
+			others = append(others, dest)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, dest := range others {
+		if dest.onTrackPublished != nil {
+			dest.onTrackPublished(source.ClientID)
+		}
+	}
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("sfu: read track from %s: %v\n", source.ClientID, err)
+			}
+			r.mu.Lock()
+			delete(r.published, source.ClientID)
+			r.mu.Unlock()
+			return
+		}
+
+		r.mu.RLock()
+		subscriberIDs := r.subscriptions[source.ClientID]
+		for id, dest := range r.subscribers {
+			if id == source.ClientID || !subscriberIDs[id] {
+				continue
+			}
+			dest.writeTrackFor(source.ClientID, packet)
+		}
+		r.mu.RUnlock()
+	}
+}
+
+// addLocalTrack lazily creates the local track that forwards sourceID's
+// published media to sub, adds it to sub.PC, and triggers renegotiation.
+func (sub *Subscriber) addLocalTrack(sourceID string, codec webrtc.RTPCodecCapability, streamID string) {
+	sub.mu.Lock()
+
+	if _, ok := sub.outbound[sourceID]; ok {
+		sub.mu.Unlock()
+		return
+	}
+
+	local, err := webrtc.NewTrackLocalStaticRTP(codec, sourceID, streamID)
+	if err != nil {
+		sub.mu.Unlock()
+		fmt.Printf("sfu: create local track for %s: %v\n", sourceID, err)
+		return
+	}
+	sender, err := sub.PC.AddTrack(local)
+	if err != nil {
+		sub.mu.Unlock()
+		fmt.Printf("sfu: add track for %s: %v\n", sourceID, err)
+		return
+	}
+
+	sub.outbound[sourceID] = local
+	sub.outboundSenders[sourceID] = sender
+	sub.mu.Unlock()
+
+	sub.triggerRenegotiate()
+}
+
+// removeLocalTrack removes the local track forwarding sourceID's media to
+// sub, if one exists, and triggers renegotiation.
+func (sub *Subscriber) removeLocalTrack(sourceID string) {
+	sub.mu.Lock()
+	sender, ok := sub.outboundSenders[sourceID]
+	delete(sub.outbound, sourceID)
+	delete(sub.outboundSenders, sourceID)
+	sub.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := sub.PC.RemoveTrack(sender); err != nil {
+		fmt.Printf("sfu: remove track for %s: %v\n", sourceID, err)
+		return
+	}
+	sub.triggerRenegotiate()
+}
+
+// triggerRenegotiate creates and sets a fresh local offer reflecting sub's
+// current outbound tracks and hands it to sub.renegotiate to ship to the
+// client. Callers must not hold sub.mu.
+func (sub *Subscriber) triggerRenegotiate() {
+	if sub.renegotiate == nil {
+		return
+	}
+
+	offer, err := sub.PC.CreateOffer(nil)
+	if err != nil {
+		fmt.Printf("sfu: create offer for %s: %v\n", sub.ClientID, err)
+		return
+	}
+	if err := sub.PC.SetLocalDescription(offer); err != nil {
+		fmt.Printf("sfu: set local description for %s: %v\n", sub.ClientID, err)
+		return
+	}
+	sub.renegotiate(offer)
+}
+
+// HandleOffer applies a client-initiated publish offer to sub's
+// PeerConnection and returns the answer to send back.
+func (sub *Subscriber) HandleOffer(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if err := sub.PC.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("sfu: set remote description for %s: %w", sub.ClientID, err)
+	}
+
+	answer, err := sub.PC.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("sfu: create answer for %s: %w", sub.ClientID, err)
+	}
+	if err := sub.PC.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("sfu: set local description for %s: %w", sub.ClientID, err)
+	}
+	return &answer, nil
+}
+
+// HandleAnswer applies the client's answer to a server-initiated
+// renegotiation offer (see triggerRenegotiate) to sub's PeerConnection.
+func (sub *Subscriber) HandleAnswer(answer webrtc.SessionDescription) error {
+	if err := sub.PC.SetRemoteDescription(answer); err != nil {
+		return fmt.Errorf("sfu: set remote description (answer) for %s: %w", sub.ClientID, err)
+	}
+	return nil
+}
+
+// AddICECandidate adds a trickled ICE candidate from the client to sub's
+// PeerConnection.
+func (sub *Subscriber) AddICECandidate(candidate webrtc.ICECandidateInit) error {
+	if err := sub.PC.AddICECandidate(candidate); err != nil {
+		return fmt.Errorf("sfu: add ICE candidate for %s: %w", sub.ClientID, err)
+	}
+	return nil
+}
+
+func (sub *Subscriber) writeTrackFor(sourceID string, packet *rtp.Packet) {
+	sub.mu.Lock()
+	track := sub.outbound[sourceID]
+	sub.mu.Unlock()
+
+	if track == nil {
+		return
+	}
+	if err := track.WriteRTP(packet); err != nil && err != io.ErrClosedPipe {
+		fmt.Printf("sfu: write track from %s: %v\n", sourceID, err)
+	}
+}